@@ -18,6 +18,8 @@ package corev1
 
 import (
 	"encoding/json"
+	"fmt"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
@@ -85,6 +87,102 @@ func FindMatchingUntoleratedTaint(taints []v1.Taint, tolerations []v1.Toleration
 	return v1.Taint{}, false
 }
 
+// noTolerationBound is returned as the duration from FindMatchingUntoleratedTaintWithTime when
+// every filtered taint is tolerated but none of the tolerated NoExecute taints have a
+// TolerationSeconds bounding how long that toleration lasts.
+const noTolerationBound time.Duration = -1
+
+// FindMatchingUntoleratedTaintWithTime behaves like FindMatchingUntoleratedTaint, but additionally
+// accounts for Toleration.TolerationSeconds on NoExecute taints. If every filtered taint is
+// currently tolerated, it returns the shortest remaining duration across all tolerated NoExecute
+// taints that bound how long the pod may keep tolerating them, i.e. the time until the pod must be
+// evicted. taintAddedTimes records when each taint (keyed by taintKey) was first observed on the
+// node; a taint missing from the map is treated as having just been added.
+//
+// Returns (taint, duration, true) if an untolerated taint was found, in which case duration is
+// always zero. Returns (v1.Taint{}, duration, false) if every filtered taint is tolerated, where
+// duration is the time remaining until the pod must be evicted, or -1 if no tolerated NoExecute
+// taint bounds the toleration (i.e. every toleration is permanent or no NoExecute taint is present).
+func FindMatchingUntoleratedTaintWithTime(
+	taints []v1.Taint,
+	tolerations []v1.Toleration,
+	now time.Time,
+	taintAddedTimes map[string]time.Time,
+	inclusionFilter taintsFilterFunc,
+) (v1.Taint, time.Duration, bool) {
+	filteredTaints := getFilteredTaints(taints, inclusionFilter)
+
+	var minRemaining time.Duration
+	haveMinRemaining := false
+
+	for _, taint := range filteredTaints {
+		var toleratingTolerations []*v1.Toleration
+		for i := range tolerations {
+			if tolerations[i].ToleratesTaint(&taint) {
+				toleratingTolerations = append(toleratingTolerations, &tolerations[i])
+			}
+		}
+		if len(toleratingTolerations) == 0 {
+			return taint, 0, true
+		}
+
+		if taint.Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+		addedAt, ok := taintAddedTimes[taintKey(taint)]
+		if !ok {
+			addedAt = now
+		}
+		for _, tol := range toleratingTolerations {
+			if tol.TolerationSeconds == nil {
+				// tolerated indefinitely, so it doesn't bound the eviction timer
+				continue
+			}
+			remaining := time.Duration(*tol.TolerationSeconds)*time.Second - now.Sub(addedAt)
+			if !haveMinRemaining || remaining < minRemaining {
+				minRemaining = remaining
+				haveMinRemaining = true
+			}
+		}
+	}
+
+	if !haveMinRemaining {
+		return v1.Taint{}, noTolerationBound, false
+	}
+	return v1.Taint{}, minRemaining, false
+}
+
+// NextTolerationExpiry walks node's taints against pod's tolerations and reports the time until
+// pod must be evicted from node, mirroring the taint-based eviction manager's logic as a reusable
+// helper. It returns false if the pod does not currently tolerate all of node's NoExecute taints
+// (it would already be evicted), or if no tolerated NoExecute taint bounds the toleration. A
+// non-negative duration is the time remaining before eviction; a negative duration means the
+// toleration has already expired and the pod is overdue for eviction.
+func NextTolerationExpiry(pod *v1.Pod, node *v1.Node, now time.Time) (time.Duration, bool) {
+	taintAddedTimes := make(map[string]time.Time, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if taint.TimeAdded != nil {
+			taintAddedTimes[taintKey(taint)] = taint.TimeAdded.Time
+		}
+	}
+
+	noExecuteFilter := func(t *v1.Taint) bool {
+		return t.Effect == v1.TaintEffectNoExecute
+	}
+
+	_, remaining, untolerated := FindMatchingUntoleratedTaintWithTime(node.Spec.Taints, pod.Spec.Tolerations, now, taintAddedTimes, noExecuteFilter)
+	if untolerated || remaining == noTolerationBound {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// taintKey returns a stable string identity for a taint, used to correlate a taint across calls
+// when tracking how long it has been present on a node.
+func taintKey(taint v1.Taint) string {
+	return fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect)
+}
+
 // getFilteredTaints returns a list of taints satisfying the filter predicate
 func getFilteredTaints(taints []v1.Taint, inclusionFilter taintsFilterFunc) []v1.Taint {
 	if inclusionFilter == nil {
@@ -110,6 +208,9 @@ const (
 	ContainerTypeContainers ContainerType = 1 << iota
 	// ContainerTypeInitContainers is for init containers
 	ContainerTypeInitContainers
+	// ContainerTypeSidecarContainers is for sidecar containers, i.e. init containers
+	// with Always restart policy
+	ContainerTypeSidecarContainers
 )
 
 // PodResourcesOptions controls the behavior of PodRequests and PodLimits
@@ -120,10 +221,24 @@ type PodResourcesOptions struct {
 	ExcludeOverhead bool
 	// ContainerFn is called with the effective resources required for each container within the pod.
 	ContainerFn func(res v1.ResourceList, containerType ContainerType)
+	// NonMissingContainerRequests if provided will replace any missing container level requests for the specified resources
+	// with the given values. If the requests for those resources are explicitly set, even if zero, they will not be modified.
+	NonMissingContainerRequests v1.ResourceList
+	// UseStatusResources indicates whether resources reported by the PodStatus should be considered
+	// when computing the pod resources. This should only be set to true if the node has enabled the
+	// in-place pod vertical scaling feature.
+	UseStatusResources bool
+	// MaxOfSpecAndStatus indicates, when UseStatusResources is set, that the reported resources should
+	// be the maximum of the Spec and Status resources rather than preferring the status. This is used
+	// to report the effective resources for a pod that is in the middle of an in-place resize, where the
+	// scheduler must continue to account for both the desired and the currently allocated resources.
+	MaxOfSpecAndStatus bool
 }
 
 // PodRequests computes the pod requests per the PodResourcesOptions supplied. If PodResourcesOptions is nil, then
-// the requests are returned including pod overhead.
+// the requests are returned including pod overhead. Sidecar containers (init containers with a restart policy of
+// Always) are added to the running total alongside the regular containers, and the init container max is computed
+// against that running total to match the effective requests the scheduler would bind against.
 func PodRequests(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 	if opts == nil {
 		// if not set, use the default behavior which also allows us to avoid a bunch of nil checks
@@ -133,20 +248,69 @@ func PodRequests(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 	// attempt to reuse the maps if passed, or allocate otherwise
 	reqs := reuseOrClearResourceList(opts.Reuse)
 
+	containerStatuses := containerStatusesByName(pod, opts.UseStatusResources)
+
 	for _, container := range pod.Spec.Containers {
+		containerReqs := container.Resources.Requests
+		if opts.UseStatusResources {
+			if cs, found := containerStatuses[container.Name]; found && cs.Resources != nil {
+				containerReqs = effectiveResourceList(container.Resources.Requests, cs.Resources.Requests, opts.MaxOfSpecAndStatus)
+			}
+		}
+		if len(opts.NonMissingContainerRequests) > 0 {
+			containerReqs = applyNonMissingContainerRequests(containerReqs, opts.NonMissingContainerRequests)
+		}
+
 		if opts.ContainerFn != nil {
-			opts.ContainerFn(container.Resources.Requests, ContainerTypeContainers)
+			opts.ContainerFn(containerReqs, ContainerTypeContainers)
 		}
-		addResourceList(reqs, container.Resources.Requests)
+		addResourceList(reqs, containerReqs)
 	}
-	// init containers define the minimum of any resource
+
+	restartableInitContainerReqs := v1.ResourceList{}
+	initContainerReqs := v1.ResourceList{}
+	// init containers define the minimum of any resource, except for sidecar containers
+	// (restartable init containers) whose resources are added to the running total since
+	// they run concurrently with the rest of the pod for its entire lifetime.
 	for _, container := range pod.Spec.InitContainers {
-		if opts.ContainerFn != nil {
-			opts.ContainerFn(container.Resources.Requests, ContainerTypeInitContainers)
+		containerReqs := container.Resources.Requests
+		if opts.UseStatusResources {
+			if cs, found := containerStatuses[container.Name]; found && cs.Resources != nil {
+				containerReqs = effectiveResourceList(container.Resources.Requests, cs.Resources.Requests, opts.MaxOfSpecAndStatus)
+			}
 		}
-		maxResourceList(reqs, container.Resources.Requests)
+		if len(opts.NonMissingContainerRequests) > 0 {
+			containerReqs = applyNonMissingContainerRequests(containerReqs, opts.NonMissingContainerRequests)
+		}
+
+		if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			// and add them to the resulting cumulative container requests
+			addResourceList(reqs, containerReqs)
+
+			// track our cumulative sidecar container resources
+			addResourceList(restartableInitContainerReqs, containerReqs)
+			containerReqs = restartableInitContainerReqs
+
+			if opts.ContainerFn != nil {
+				opts.ContainerFn(containerReqs, ContainerTypeSidecarContainers)
+			}
+		} else {
+			// for non-sidecar init containers, the effective requests include any
+			// sidecar containers that have already started running alongside them
+			tmp := v1.ResourceList{}
+			addResourceList(tmp, containerReqs)
+			addResourceList(tmp, restartableInitContainerReqs)
+			containerReqs = tmp
+
+			if opts.ContainerFn != nil {
+				opts.ContainerFn(containerReqs, ContainerTypeInitContainers)
+			}
+		}
+		maxResourceList(initContainerReqs, containerReqs)
 	}
 
+	maxResourceList(reqs, initContainerReqs)
+
 	// Add overhead for running a pod to the sum of requests if requested:
 	if !opts.ExcludeOverhead && pod.Spec.Overhead != nil {
 		addResourceList(reqs, pod.Spec.Overhead)
@@ -156,7 +320,10 @@ func PodRequests(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 }
 
 // PodLimits computes the pod limits per the PodResourcesOptions supplied. If PodResourcesOptions is nil, then
-// the limits are returned including pod overhead for any non-zero limits.
+// the limits are returned including pod overhead for any non-zero limits. Sidecar containers (init containers
+// with a restart policy of Always) are added to the running total alongside the regular containers, and the
+// init container max is computed against that running total to match the effective limits the scheduler would
+// bind against.
 func PodLimits(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 	if opts == nil {
 		// if not set, use the default behavior which also allows us to avoid a bunch of nil checks
@@ -166,20 +333,62 @@ func PodLimits(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 	// attempt to reuse the maps if passed, or allocate otherwise
 	limits := reuseOrClearResourceList(opts.Reuse)
 
+	containerStatuses := containerStatusesByName(pod, opts.UseStatusResources)
+
 	for _, container := range pod.Spec.Containers {
+		containerLimits := container.Resources.Limits
+		if opts.UseStatusResources {
+			if cs, found := containerStatuses[container.Name]; found && cs.Resources != nil {
+				containerLimits = effectiveResourceList(container.Resources.Limits, cs.Resources.Limits, opts.MaxOfSpecAndStatus)
+			}
+		}
+
 		if opts.ContainerFn != nil {
-			opts.ContainerFn(container.Resources.Limits, ContainerTypeContainers)
+			opts.ContainerFn(containerLimits, ContainerTypeContainers)
 		}
-		addResourceList(limits, container.Resources.Limits)
+		addResourceList(limits, containerLimits)
 	}
-	// init containers define the minimum of any resource
+
+	restartableInitContainerLimits := v1.ResourceList{}
+	initContainerLimits := v1.ResourceList{}
+	// init containers define the minimum of any resource, except for sidecar containers
+	// (restartable init containers) whose resources are added to the running total since
+	// they run concurrently with the rest of the pod for its entire lifetime.
 	for _, container := range pod.Spec.InitContainers {
-		if opts.ContainerFn != nil {
-			opts.ContainerFn(container.Resources.Limits, ContainerTypeInitContainers)
+		containerLimits := container.Resources.Limits
+		if opts.UseStatusResources {
+			if cs, found := containerStatuses[container.Name]; found && cs.Resources != nil {
+				containerLimits = effectiveResourceList(container.Resources.Limits, cs.Resources.Limits, opts.MaxOfSpecAndStatus)
+			}
+		}
+		if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			// and add them to the resulting cumulative container limits
+			addResourceList(limits, containerLimits)
+
+			// track our cumulative sidecar container resources
+			addResourceList(restartableInitContainerLimits, containerLimits)
+			containerLimits = restartableInitContainerLimits
+
+			if opts.ContainerFn != nil {
+				opts.ContainerFn(containerLimits, ContainerTypeSidecarContainers)
+			}
+		} else {
+			// for non-sidecar init containers, the effective limits include any
+			// sidecar containers that have already started running alongside them
+			tmp := v1.ResourceList{}
+			addResourceList(tmp, containerLimits)
+			addResourceList(tmp, restartableInitContainerLimits)
+			containerLimits = tmp
+
+			if opts.ContainerFn != nil {
+				opts.ContainerFn(containerLimits, ContainerTypeInitContainers)
+			}
 		}
-		maxResourceList(limits, container.Resources.Limits)
+		maxResourceList(initContainerLimits, containerLimits)
 	}
 
+	maxResourceList(limits, initContainerLimits)
+
 	// Add overhead to non-zero limits if requested:
 	if !opts.ExcludeOverhead && pod.Spec.Overhead != nil {
 		for name, quantity := range pod.Spec.Overhead {
@@ -193,6 +402,53 @@ func PodLimits(pod *v1.Pod, opts *PodResourcesOptions) v1.ResourceList {
 	return limits
 }
 
+// containerStatusesByName indexes pod.Status.ContainerStatuses and pod.Status.InitContainerStatuses by
+// container name, for use when UseStatusResources is set. It returns nil when useStatusResources is false
+// so that callers can skip the lookup entirely.
+func containerStatusesByName(pod *v1.Pod, useStatusResources bool) map[string]*v1.ContainerStatus {
+	if !useStatusResources {
+		return nil
+	}
+	statuses := make(map[string]*v1.ContainerStatus, len(pod.Status.ContainerStatuses)+len(pod.Status.InitContainerStatuses))
+	for i := range pod.Status.ContainerStatuses {
+		statuses[pod.Status.ContainerStatuses[i].Name] = &pod.Status.ContainerStatuses[i]
+	}
+	for i := range pod.Status.InitContainerStatuses {
+		statuses[pod.Status.InitContainerStatuses[i].Name] = &pod.Status.InitContainerStatuses[i]
+	}
+	return statuses
+}
+
+// effectiveResourceList returns the resources to use for a container that has reported status resources.
+// If maxOfSpecAndStatus is set, it returns the per-resource max of spec and status, since the scheduler-facing
+// footprint of a resizing pod is the max of desired and actual. Otherwise it prefers the status resources,
+// since those reflect what was actually allocated to the container.
+func effectiveResourceList(specResources, statusResources v1.ResourceList, maxOfSpecAndStatus bool) v1.ResourceList {
+	if !maxOfSpecAndStatus {
+		return statusResources
+	}
+	effective := make(v1.ResourceList, len(specResources))
+	addResourceList(effective, specResources)
+	maxResourceList(effective, statusResources)
+	return effective
+}
+
+// applyNonMissingContainerRequests returns a copy of containerReqs with any resource present in
+// defaults substituted in when containerReqs does not already specify that resource, even implicitly
+// as zero.
+func applyNonMissingContainerRequests(containerReqs, defaults v1.ResourceList) v1.ResourceList {
+	result := make(v1.ResourceList, len(containerReqs)+len(defaults))
+	for name, quantity := range containerReqs {
+		result[name] = quantity
+	}
+	for name, quantity := range defaults {
+		if _, found := result[name]; !found {
+			result[name] = quantity
+		}
+	}
+	return result
+}
+
 // addResourceList adds the resources in newList to list.
 func addResourceList(list, newList v1.ResourceList) {
 	for name, quantity := range newList {
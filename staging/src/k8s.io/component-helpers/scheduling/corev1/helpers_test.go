@@ -0,0 +1,320 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package corev1
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func mustParse(s string) resource.Quantity {
+	return resource.MustParse(s)
+}
+
+func resourceList(cpu, mem string) v1.ResourceList {
+	return v1.ResourceList{
+		v1.ResourceCPU:    mustParse(cpu),
+		v1.ResourceMemory: mustParse(mem),
+	}
+}
+
+func requirements(cpu, mem string) v1.ResourceRequirements {
+	return v1.ResourceRequirements{Requests: resourceList(cpu, mem)}
+}
+
+func resourceListsEqual(t *testing.T, got, want v1.ResourceList) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v resources, want %v", got, want)
+	}
+	for name, wantQty := range want {
+		gotQty, ok := got[name]
+		if !ok {
+			t.Fatalf("missing resource %s in %v", name, got)
+		}
+		if gotQty.Cmp(wantQty) != 0 {
+			t.Errorf("resource %s: got %s, want %s", name, gotQty.String(), wantQty.String())
+		}
+	}
+}
+
+func restartAlways() *v1.ContainerRestartPolicy {
+	p := v1.ContainerRestartPolicyAlways
+	return &p
+}
+
+func TestPodRequestsSidecarContainers(t *testing.T) {
+	cases := []struct {
+		name string
+		pod  *v1.Pod
+		want v1.ResourceList
+	}{
+		{
+			name: "no init containers",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{Resources: requirements("1", "1Gi")}},
+			}},
+			want: resourceList("1", "1Gi"),
+		},
+		{
+			name: "non-sidecar init container maxes against regular containers",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers:     []v1.Container{{Resources: requirements("1", "1Gi")}},
+				InitContainers: []v1.Container{{Resources: requirements("2", "512Mi")}},
+			}},
+			want: resourceList("2", "1Gi"),
+		},
+		{
+			name: "sidecar container is added to the running total",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{Resources: requirements("1", "1Gi")}},
+				InitContainers: []v1.Container{
+					{Resources: requirements("1", "256Mi"), RestartPolicy: restartAlways()},
+				},
+			}},
+			want: resourceList("2", "1280Mi"),
+		},
+		{
+			name: "non-sidecar init container is maxed against regular+sidecar total",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{Resources: requirements("1", "1Gi")}},
+				InitContainers: []v1.Container{
+					{Resources: requirements("1", "256Mi"), RestartPolicy: restartAlways()},
+					{Resources: requirements("3", "1Gi")},
+				},
+			}},
+			want: resourceList("3", "1280Mi"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resourceListsEqual(t, PodRequests(tc.pod, &PodResourcesOptions{}), tc.want)
+		})
+	}
+}
+
+func TestPodRequestsNonMissingContainerRequests(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{
+		Containers: []v1.Container{
+			{Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: mustParse("1")}}},
+		},
+	}}
+
+	got := PodRequests(pod, &PodResourcesOptions{
+		NonMissingContainerRequests: v1.ResourceList{v1.ResourceMemory: mustParse("100Mi")},
+	})
+
+	resourceListsEqual(t, got, resourceList("1", "100Mi"))
+}
+
+func TestPodRequestsUseStatusResources(t *testing.T) {
+	cases := []struct {
+		name string
+		pod  *v1.Pod
+		opts *PodResourcesOptions
+		want v1.ResourceList
+	}{
+		{
+			name: "no status present falls back to spec",
+			pod: &v1.Pod{Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "c", Resources: requirements("1", "1Gi")}},
+			}},
+			opts: &PodResourcesOptions{UseStatusResources: true},
+			want: resourceList("1", "1Gi"),
+		},
+		{
+			name: "partial status only covers some containers",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Name: "a", Resources: requirements("1", "1Gi")},
+						{Name: "b", Resources: requirements("1", "1Gi")},
+					},
+				},
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{
+						{Name: "a", Resources: &v1.ResourceRequirements{Requests: resourceList("2", "2Gi")}},
+					},
+				},
+			},
+			opts: &PodResourcesOptions{UseStatusResources: true},
+			want: resourceList("3", "3Gi"),
+		},
+		{
+			name: "resize in progress prefers status when lower than spec",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "c", Resources: requirements("2", "2Gi")}},
+				},
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{
+						{Name: "c", Resources: &v1.ResourceRequirements{Requests: resourceList("1", "1Gi")}},
+					},
+				},
+			},
+			opts: &PodResourcesOptions{UseStatusResources: true},
+			want: resourceList("1", "1Gi"),
+		},
+		{
+			name: "resize down takes max of spec and status when requested",
+			pod: &v1.Pod{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "c", Resources: requirements("1", "1Gi")}},
+				},
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{
+						{Name: "c", Resources: &v1.ResourceRequirements{Requests: resourceList("2", "2Gi")}},
+					},
+				},
+			},
+			opts: &PodResourcesOptions{UseStatusResources: true, MaxOfSpecAndStatus: true},
+			want: resourceList("2", "2Gi"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resourceListsEqual(t, PodRequests(tc.pod, tc.opts), tc.want)
+		})
+	}
+}
+
+func TestFindMatchingUntoleratedTaintWithTime(t *testing.T) {
+	now := time.Unix(1000, 0)
+	noExecute := v1.Taint{Key: "k", Value: "v", Effect: v1.TaintEffectNoExecute}
+
+	t.Run("untolerated taint is reported immediately", func(t *testing.T) {
+		_, _, found := FindMatchingUntoleratedTaintWithTime([]v1.Taint{noExecute}, nil, now, nil, nil)
+		if !found {
+			t.Fatalf("expected an untolerated taint to be found")
+		}
+	})
+
+	t.Run("permanently tolerated taint has no bound", func(t *testing.T) {
+		tolerations := []v1.Toleration{{Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute}}
+		_, duration, found := FindMatchingUntoleratedTaintWithTime([]v1.Taint{noExecute}, tolerations, now, nil, nil)
+		if found {
+			t.Fatalf("expected taint to be tolerated")
+		}
+		if duration != noTolerationBound {
+			t.Fatalf("got duration %v, want noTolerationBound", duration)
+		}
+	})
+
+	t.Run("bounded toleration returns remaining time", func(t *testing.T) {
+		seconds := int64(60)
+		tolerations := []v1.Toleration{{
+			Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute,
+			TolerationSeconds: &seconds,
+		}}
+		addedTimes := map[string]time.Time{taintKey(noExecute): now.Add(-10 * time.Second)}
+
+		_, duration, found := FindMatchingUntoleratedTaintWithTime([]v1.Taint{noExecute}, tolerations, now, addedTimes, nil)
+		if found {
+			t.Fatalf("expected taint to be tolerated")
+		}
+		if want := 50 * time.Second; duration != want {
+			t.Fatalf("got duration %v, want %v", duration, want)
+		}
+	})
+
+	t.Run("expired toleration returns a negative duration", func(t *testing.T) {
+		seconds := int64(10)
+		tolerations := []v1.Toleration{{
+			Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute,
+			TolerationSeconds: &seconds,
+		}}
+		addedTimes := map[string]time.Time{taintKey(noExecute): now.Add(-30 * time.Second)}
+
+		_, duration, found := FindMatchingUntoleratedTaintWithTime([]v1.Taint{noExecute}, tolerations, now, addedTimes, nil)
+		if found {
+			t.Fatalf("expected taint to be tolerated")
+		}
+		if want := -20 * time.Second; duration != want {
+			t.Fatalf("got duration %v, want %v", duration, want)
+		}
+	})
+
+	t.Run("takes the shortest remaining duration across taints", func(t *testing.T) {
+		other := v1.Taint{Key: "k2", Value: "v2", Effect: v1.TaintEffectNoExecute}
+		shortSeconds := int64(20)
+		longSeconds := int64(100)
+		tolerations := []v1.Toleration{
+			{Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute, TolerationSeconds: &longSeconds},
+			{Key: "k2", Operator: v1.TolerationOpEqual, Value: "v2", Effect: v1.TaintEffectNoExecute, TolerationSeconds: &shortSeconds},
+		}
+		addedTimes := map[string]time.Time{
+			taintKey(noExecute): now,
+			taintKey(other):     now,
+		}
+
+		_, duration, found := FindMatchingUntoleratedTaintWithTime([]v1.Taint{noExecute, other}, tolerations, now, addedTimes, nil)
+		if found {
+			t.Fatalf("expected taints to be tolerated")
+		}
+		if want := 20 * time.Second; duration != want {
+			t.Fatalf("got duration %v, want %v", duration, want)
+		}
+	})
+}
+
+func TestNextTolerationExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	addedAt := metav1.NewTime(now.Add(-10 * time.Second))
+	seconds := int64(60)
+
+	node := &v1.Node{Spec: v1.NodeSpec{Taints: []v1.Taint{
+		{Key: "k", Value: "v", Effect: v1.TaintEffectNoExecute, TimeAdded: &addedAt},
+	}}}
+
+	t.Run("pod tolerates with a bound", func(t *testing.T) {
+		pod := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{
+			{Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+		}}}
+
+		duration, ok := NextTolerationExpiry(pod, node, now)
+		if !ok {
+			t.Fatalf("expected a bounded expiry")
+		}
+		if want := 50 * time.Second; duration != want {
+			t.Fatalf("got duration %v, want %v", duration, want)
+		}
+	})
+
+	t.Run("pod does not tolerate the taint", func(t *testing.T) {
+		pod := &v1.Pod{}
+
+		if _, ok := NextTolerationExpiry(pod, node, now); ok {
+			t.Fatalf("expected no expiry for a pod that is already evicted")
+		}
+	})
+
+	t.Run("pod tolerates indefinitely", func(t *testing.T) {
+		pod := &v1.Pod{Spec: v1.PodSpec{Tolerations: []v1.Toleration{
+			{Key: "k", Operator: v1.TolerationOpEqual, Value: "v", Effect: v1.TaintEffectNoExecute},
+		}}}
+
+		if _, ok := NextTolerationExpiry(pod, node, now); ok {
+			t.Fatalf("expected no bounded expiry for a permanent toleration")
+		}
+	})
+}
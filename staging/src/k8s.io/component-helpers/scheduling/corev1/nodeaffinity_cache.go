@@ -0,0 +1,151 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package corev1
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+)
+
+// CompiledNodeSelector is a v1.NodeSelector that has been parsed once, so that it can be matched
+// against many nodes without re-parsing its MatchExpressions/MatchFields terms on every call.
+type CompiledNodeSelector struct {
+	selector *nodeaffinity.LazyErrorNodeSelector
+}
+
+// Compile parses sel once, returning a CompiledNodeSelector that can be reused to Match many
+// nodes without re-parsing sel's terms each time.
+func Compile(sel *v1.NodeSelector) (*CompiledNodeSelector, error) {
+	if sel == nil {
+		return nil, fmt.Errorf("node selector is nil")
+	}
+	return &CompiledNodeSelector{selector: nodeaffinity.NewLazyErrorNodeSelector(sel)}, nil
+}
+
+// Match reports whether node satisfies the compiled selector. A term that fails to evaluate
+// (e.g. an invalid operator) is treated as not matching.
+func (c *CompiledNodeSelector) Match(node *v1.Node) bool {
+	if node == nil {
+		return false
+	}
+	ok, err := c.selector.Match(node)
+	return err == nil && ok
+}
+
+// NodeSelectorCache caches CompiledNodeSelectors keyed by the identity of the *v1.NodeSelector
+// they were compiled from, evicting the least recently used entry once the cache is full. It is
+// safe for concurrent use. Callers should share a NodeSelectorCache across the many nodes a
+// single pod's affinity is evaluated against, e.g. within one scheduling cycle.
+type NodeSelectorCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[*v1.NodeSelector]*list.Element
+	order    *list.List // front = most recently used
+}
+
+type nodeSelectorCacheEntry struct {
+	key      *v1.NodeSelector
+	compiled *CompiledNodeSelector
+}
+
+// NewNodeSelectorCache returns a NodeSelectorCache holding up to capacity compiled selectors. A
+// capacity <= 0 is treated as 1 rather than unbounded, so the cache always evicts.
+func NewNodeSelectorCache(capacity int) *NodeSelectorCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &NodeSelectorCache{
+		capacity: capacity,
+		entries:  make(map[*v1.NodeSelector]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// getOrCompile returns the CompiledNodeSelector for sel, compiling and caching it if this is the
+// first time sel has been seen.
+func (c *NodeSelectorCache) getOrCompile(sel *v1.NodeSelector) (*CompiledNodeSelector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[sel]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*nodeSelectorCacheEntry).compiled, nil
+	}
+
+	compiled, err := Compile(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	elem := c.order.PushFront(&nodeSelectorCacheEntry{key: sel, compiled: compiled})
+	c.entries[sel] = elem
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*nodeSelectorCacheEntry).key)
+		}
+	}
+
+	return compiled, nil
+}
+
+// MatchNodeSelectorTermsCached is like MatchNodeSelectorTerms, but reuses a CompiledNodeSelector
+// for sel from cache across calls instead of re-parsing its terms every time. This is the hot
+// loop in schedulers and admission controllers that evaluate the same pod's affinity against many
+// nodes.
+func MatchNodeSelectorTermsCached(cache *NodeSelectorCache, node *v1.Node, sel *v1.NodeSelector) (bool, error) {
+	if node == nil {
+		return false, nil
+	}
+	compiled, err := cache.getOrCompile(sel)
+	if err != nil {
+		return false, err
+	}
+	return compiled.Match(node), nil
+}
+
+// CompiledPreferredSchedulingTerms is a compiled form of []v1.PreferredSchedulingTerm that scores
+// many nodes without re-parsing each term's selector every time.
+type CompiledPreferredSchedulingTerms struct {
+	terms *nodeaffinity.PreferredSchedulingTerms
+}
+
+// CompilePreferred parses terms once, returning a matcher that can score many nodes against them
+// without re-parsing each term's selector every time.
+func CompilePreferred(terms []v1.PreferredSchedulingTerm) (*CompiledPreferredSchedulingTerms, error) {
+	compiled, err := nodeaffinity.NewPreferredSchedulingTerms(terms)
+	if err != nil {
+		return nil, err
+	}
+	return &CompiledPreferredSchedulingTerms{terms: compiled}, nil
+}
+
+// Score returns the summed weight of every preferred term that matches node, computed in a
+// single pass over the compiled terms. This is the hot loop in the NodeAffinity and
+// InterPodAffinity scoring plugins.
+func (c *CompiledPreferredSchedulingTerms) Score(node *v1.Node) int64 {
+	if c == nil || node == nil {
+		return 0
+	}
+	return c.terms.Score(node)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package corev1
+
+import (
+	"sync"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeSelectorForZone(zone string) *v1.NodeSelector {
+	return &v1.NodeSelector{
+		NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchExpressions: []v1.NodeSelectorRequirement{{
+				Key:      "zone",
+				Operator: v1.NodeSelectorOpIn,
+				Values:   []string{zone},
+			}},
+		}},
+	}
+}
+
+func nodeWithZone(zone string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"zone": zone}}}
+}
+
+func TestCompiledNodeSelectorMatch(t *testing.T) {
+	compiled, err := Compile(nodeSelectorForZone("a"))
+	if err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+
+	if !compiled.Match(nodeWithZone("a")) {
+		t.Errorf("expected node in zone a to match")
+	}
+	if compiled.Match(nodeWithZone("b")) {
+		t.Errorf("expected node in zone b not to match")
+	}
+	if compiled.Match(nil) {
+		t.Errorf("expected nil node not to match")
+	}
+}
+
+func TestCompile_NilSelector(t *testing.T) {
+	if _, err := Compile(nil); err == nil {
+		t.Errorf("expected an error compiling a nil selector")
+	}
+}
+
+func TestNodeSelectorCacheReusesCompiledSelector(t *testing.T) {
+	cache := NewNodeSelectorCache(2)
+	sel := nodeSelectorForZone("a")
+
+	match, err := MatchNodeSelectorTermsCached(cache, nodeWithZone("a"), sel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !match {
+		t.Fatalf("expected node in zone a to match")
+	}
+
+	// A second lookup against the same *v1.NodeSelector pointer should hit the cache
+	// rather than compiling a second entry.
+	if got := cache.order.Len(); got != 1 {
+		t.Fatalf("got %d cached entries, want 1", got)
+	}
+
+	if _, err := MatchNodeSelectorTermsCached(cache, nodeWithZone("b"), sel); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := cache.order.Len(); got != 1 {
+		t.Fatalf("got %d cached entries after a repeat lookup, want 1", got)
+	}
+}
+
+func TestNodeSelectorCacheLRUEviction(t *testing.T) {
+	cache := NewNodeSelectorCache(2)
+	a, b, c := nodeSelectorForZone("a"), nodeSelectorForZone("b"), nodeSelectorForZone("c")
+
+	mustCompile := func(sel *v1.NodeSelector) {
+		if _, err := cache.getOrCompile(sel); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	mustCompile(a)
+	mustCompile(b)
+	// touch a again so it becomes more recently used than b
+	mustCompile(a)
+	// c should evict b, the least recently used entry, not a
+	mustCompile(c)
+
+	if _, ok := cache.entries[b]; ok {
+		t.Errorf("expected b to have been evicted")
+	}
+	if _, ok := cache.entries[a]; !ok {
+		t.Errorf("expected a to still be cached")
+	}
+	if _, ok := cache.entries[c]; !ok {
+		t.Errorf("expected c to be cached")
+	}
+	if got := cache.order.Len(); got != 2 {
+		t.Fatalf("got %d cached entries, want 2", got)
+	}
+}
+
+func TestNewNodeSelectorCacheNonPositiveCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -1, -100} {
+		cache := NewNodeSelectorCache(capacity)
+		a, b := nodeSelectorForZone("a"), nodeSelectorForZone("b")
+
+		if _, err := cache.getOrCompile(a); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := cache.getOrCompile(b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := cache.order.Len(); got != 1 {
+			t.Errorf("capacity %d: got %d cached entries, want 1 (non-positive capacity must not mean unbounded)", capacity, got)
+		}
+		if _, ok := cache.entries[a]; ok {
+			t.Errorf("capacity %d: expected the first entry to have been evicted", capacity)
+		}
+		if _, ok := cache.entries[b]; !ok {
+			t.Errorf("capacity %d: expected the most recent entry to still be cached", capacity)
+		}
+	}
+}
+
+func TestNodeSelectorCacheConcurrentAccess(t *testing.T) {
+	cache := NewNodeSelectorCache(4)
+	selectors := []*v1.NodeSelector{
+		nodeSelectorForZone("a"),
+		nodeSelectorForZone("b"),
+		nodeSelectorForZone("c"),
+	}
+	node := nodeWithZone("a")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		sel := selectors[i%len(selectors)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := MatchNodeSelectorTermsCached(cache, node, sel); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCompiledPreferredSchedulingTermsScore(t *testing.T) {
+	terms := []v1.PreferredSchedulingTerm{
+		{
+			Weight: 10,
+			Preference: v1.NodeSelectorTerm{
+				MatchExpressions: []v1.NodeSelectorRequirement{{
+					Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"},
+				}},
+			},
+		},
+		{
+			Weight: 5,
+			Preference: v1.NodeSelectorTerm{
+				MatchExpressions: []v1.NodeSelectorRequirement{{
+					Key: "disk", Operator: v1.NodeSelectorOpIn, Values: []string{"ssd"},
+				}},
+			},
+		},
+	}
+
+	compiled, err := CompilePreferred(terms)
+	if err != nil {
+		t.Fatalf("CompilePreferred returned error: %v", err)
+	}
+
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"zone": "a",
+		"disk": "ssd",
+	}}}
+	if got, want := compiled.Score(node), int64(15); got != want {
+		t.Errorf("got score %d, want %d", got, want)
+	}
+
+	partial := &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"zone": "a"}}}
+	if got, want := compiled.Score(partial), int64(10); got != want {
+		t.Errorf("got score %d, want %d", got, want)
+	}
+
+	if got, want := compiled.Score(nil), int64(0); got != want {
+		t.Errorf("got score %d, want %d for a nil node", got, want)
+	}
+}
@@ -0,0 +1,377 @@
+/*
+Copyright 2023 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mountutils provides a pure-Go, cached watchdog for detecting hung mounts, for use by
+// callers (e.g. the kubelet) that need to avoid blocking on a wedged NFS/FUSE filesystem.
+package mountutils
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultHangProbeTimeout is how long we wait for a probe of a mount before declaring it hung.
+const defaultHangProbeTimeout = 1 * time.Second
+
+// defaultHangCacheTTL is how long a mount's hung/not-hung verdict is cached before we probe it
+// again.
+const defaultHangCacheTTL = 5 * time.Second
+
+var (
+	hangProbeTimeout atomic.Int64
+	hangCacheTTL     atomic.Int64
+	hangCache        sync.Map // mount point (string) -> hangCacheEntry
+	mountPointCache  sync.Map // path (string) -> mountPointCacheEntry
+
+	// inFlightProbes and inFlightResolves ensure that a mount or path that is actually hung
+	// accumulates at most one permanently-blocked goroutine, no matter how many times
+	// IsFilesystemHung is called against it or how many cache TTL windows elapse while it
+	// stays hung. A blocked syscall can't be cancelled, so the only way to bound the damage
+	// is to never start a second one while the first is still outstanding.
+	inFlightProbes   sync.Map // mount point (string) -> *probeCall
+	inFlightResolves sync.Map // path (string) -> *resolveCall
+)
+
+func init() {
+	hangProbeTimeout.Store(int64(defaultHangProbeTimeout))
+	hangCacheTTL.Store(int64(defaultHangCacheTTL))
+}
+
+type hangCacheEntry struct {
+	hung      bool
+	expiresAt time.Time
+}
+
+type mountPointCacheEntry struct {
+	mount     string
+	expiresAt time.Time
+}
+
+// SetHangProbeTimeout sets how long a probe is allowed to run before its mount is considered
+// hung. Intended for tests and operators tuning the watchdog.
+func SetHangProbeTimeout(d time.Duration) {
+	hangProbeTimeout.Store(int64(d))
+}
+
+// SetHangCacheTTL sets how long a mount's hung/not-hung verdict is cached before it is probed
+// again. Intended for tests and operators tuning the watchdog.
+func SetHangCacheTTL(d time.Duration) {
+	hangCacheTTL.Store(int64(d))
+}
+
+// InvalidateHangCache discards any cached mount point resolution for path and, when that
+// resolution was cached, the hang verdict of the mount it resolved to. If path has no cached
+// resolution, a verdict cached for its mount (e.g. via another path) is left in place.
+func InvalidateHangCache(path string) {
+	if entry, ok := mountPointCache.Load(path); ok {
+		hangCache.Delete(entry.(mountPointCacheEntry).mount)
+	}
+	mountPointCache.Delete(path)
+}
+
+// FileExists reports whether file exists, treating a hung filesystem the same as a missing file
+// rather than blocking indefinitely on os.Stat.
+func FileExists(file string) bool {
+	if IsFilesystemHung(file) {
+		return false
+	}
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	return true
+}
+
+// IsFilesystemHung reports whether the filesystem backing path is unresponsive. Both the mount
+// point resolution and the hang verdict are cached per SetHangCacheTTL so hot callers like
+// FileExists don't pay a syscall per invocation.
+func IsFilesystemHung(path string) bool {
+	mount, timedOut, ok := resolveMountPoint(path)
+	if timedOut {
+		// resolving the mount point itself hung, which is exactly the condition we're
+		// trying to detect.
+		return true
+	}
+	if !ok {
+		// if we can't even stat the path to find its mount, treat it as not hung;
+		// the caller's own os.Stat will surface the real error.
+		return false
+	}
+
+	if entry, ok := hangCache.Load(mount); ok {
+		cached := entry.(hangCacheEntry)
+		if time.Now().Before(cached.expiresAt) {
+			return cached.hung
+		}
+	}
+
+	hung := probeMount(mount)
+	hangCache.Store(mount, hangCacheEntry{
+		hung:      hung,
+		expiresAt: time.Now().Add(time.Duration(hangCacheTTL.Load())),
+	})
+	return hung
+}
+
+// probeCall is the outcome of a single, possibly still-running, probe of a mount.
+type probeCall struct {
+	done chan struct{}
+	hung bool
+}
+
+// probeMount reports whether mount is hung, coalescing concurrent and repeated callers onto a
+// single outstanding probe per mount. Statfs runs in a goroutine since there is no way to cancel
+// a syscall blocked in the kernel on a genuinely hung filesystem; joining an in-flight probe
+// instead of starting a new one each time is what keeps a persistently hung mount from leaking an
+// unbounded number of permanently-blocked goroutines over time.
+func probeMount(mount string) bool {
+	call := joinOrStartProbe(mount)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hangProbeTimeout.Load()))
+	defer cancel()
+
+	select {
+	case <-call.done:
+		return call.hung
+	case <-ctx.Done():
+		log.Printf("Filesystem where %s resides appears hung", mount)
+		return true
+	}
+}
+
+func joinOrStartProbe(mount string) *probeCall {
+	if v, ok := inFlightProbes.Load(mount); ok {
+		return v.(*probeCall)
+	}
+
+	call := &probeCall{done: make(chan struct{})}
+	actual, loaded := inFlightProbes.LoadOrStore(mount, call)
+	if loaded {
+		return actual.(*probeCall)
+	}
+
+	go func() {
+		// we don't actually care if the call succeeds or fails, just whether it returns
+		var stat unix.Statfs_t
+		_ = unix.Statfs(mount, &stat)
+		call.hung = false
+		close(call.done)
+		inFlightProbes.Delete(mount)
+	}()
+
+	return call
+}
+
+// resolveCall is the outcome of a single, possibly still-running, mount point resolution.
+type resolveCall struct {
+	done  chan struct{}
+	mount string
+	err   error
+}
+
+// resolveMountPoint resolves path to its mount point, consulting and populating
+// mountPointCache so repeat calls on a live mount skip the directory walk entirely. The walk
+// itself runs in a goroutine shared by all concurrent/repeated callers for path, since a hung
+// filesystem blocks syscall.Stat the same way it blocks the unix.Statfs call in probeMount, and
+// for the same reason a leaked blocked goroutine must be bounded to at most one per path.
+//
+// Returns timedOut=true if resolution itself hung; in that case mount and ok are meaningless.
+// Returns ok=false if the path could not be resolved (e.g. it doesn't exist).
+func resolveMountPoint(path string) (mount string, timedOut bool, ok bool) {
+	if entry, found := mountPointCache.Load(path); found {
+		cached := entry.(mountPointCacheEntry)
+		if time.Now().Before(cached.expiresAt) {
+			return cached.mount, false, true
+		}
+	}
+
+	call := joinOrStartResolve(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(hangProbeTimeout.Load()))
+	defer cancel()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return "", false, false
+		}
+		mountPointCache.Store(path, mountPointCacheEntry{
+			mount:     call.mount,
+			expiresAt: time.Now().Add(time.Duration(hangCacheTTL.Load())),
+		})
+		return call.mount, false, true
+	case <-ctx.Done():
+		log.Printf("Resolving mount point for %s appears hung", path)
+		return "", true, false
+	}
+}
+
+func joinOrStartResolve(path string) *resolveCall {
+	if v, ok := inFlightResolves.Load(path); ok {
+		return v.(*resolveCall)
+	}
+
+	call := &resolveCall{done: make(chan struct{})}
+	actual, loaded := inFlightResolves.LoadOrStore(path, call)
+	if loaded {
+		return actual.(*resolveCall)
+	}
+
+	go func() {
+		call.mount, call.err = mountPoint(path)
+		close(call.done)
+		inFlightResolves.Delete(path)
+	}()
+
+	return call
+}
+
+// mountPoint resolves path to the mount point it resides on by walking up its
+// parent directories and comparing device numbers.
+func mountPoint(path string) (string, error) {
+	path, err := filepathAbs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var pathStat syscall.Stat_t
+	if err := syscall.Stat(path, &pathStat); err != nil {
+		return "", err
+	}
+	dev := pathStat.Dev
+
+	dir := path
+	for {
+		parent := parentDir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+
+		var parentStat syscall.Stat_t
+		if err := syscall.Stat(parent, &parentStat); err != nil {
+			return dir, nil
+		}
+		if parentStat.Dev != dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+func filepathAbs(path string) (string, error) {
+	if strings.HasPrefix(path, "/") {
+		return path, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return wd + "/" + path, nil
+}
+
+func parentDir(path string) string {
+	if path == "/" || path == "" {
+		return "/"
+	}
+	idx := strings.LastIndex(strings.TrimSuffix(path, "/"), "/")
+	if idx <= 0 {
+		return "/"
+	}
+	return path[:idx]
+}
+
+// ProbeAllMounts probes every mount listed in /proc/self/mountinfo concurrently and
+// returns whether each one appears hung, keyed by mount point. Useful for
+// kubelet-style periodic health reporting across all mounted filesystems.
+func ProbeAllMounts(ctx context.Context) map[string]bool {
+	mounts, err := readMountPoints()
+	if err != nil {
+		return nil
+	}
+
+	results := make(map[string]bool, len(mounts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, mount := range mounts {
+		mount := mount
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hung := IsFilesystemHung(mount)
+			mu.Lock()
+			results[mount] = hung
+			mu.Unlock()
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]bool, len(results))
+	for k, v := range results {
+		out[k] = v
+	}
+	return out
+}
+
+// readMountPoints parses /proc/self/mountinfo and returns the list of mount points.
+func readMountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseMountInfo(f)
+}
+
+// parseMountInfo parses the /proc/[pid]/mountinfo format and returns the list of mount points,
+// split out from readMountPoints so the parsing itself can be unit tested without /proc.
+func parseMountInfo(r io.Reader) ([]string, error) {
+	var mounts []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// mountinfo format: ... <mount point> ...; the mount point is always field index 4
+		if len(fields) < 5 {
+			continue
+		}
+		mounts = append(mounts, fields[4])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}